@@ -17,104 +17,69 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"flag"
 	"fmt"
-	"math"
 	"os"
 	"os/exec"
 	"syscall"
 
-	"github.com/golang/protobuf/proto"
-	"github.com/trezor/trezord-go/trezorapi"
-	"github.com/trezor/trezord-go/trezorapi/trezorpb"
-	"github.com/trezor/trezord-go/trezorapi/trezorpb/trezorpbcall"
+	"github.com/petrkr/trezorencrypt/pkg/trezorencrypt"
 )
 
-var (
-	iv = []byte("trezorEncrypt IV")
-)
+// execPrompter answers PIN/passphrase requests by shelling out to the
+// trezor-askpass helper, the same behavior the CLI has always had.
+type execPrompter struct{}
 
-func usage() int {
-	flag.Usage()
-	return int(syscall.EINVAL)
-}
+func (execPrompter) ask(prompt string) (string, error) {
+	cmd := exec.Command("trezor-askpass", prompt)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
 
-func checkError(err error) {
-	if err == nil {
-		return
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
 	}
 
-	fmt.Fprintln(os.Stderr, "Got error:", err)
-	os.Exit(255)
+	return string(out), nil
 }
 
-func makeStringPointer(v string) *string {
-	return &v
+func (p execPrompter) GetPIN() (string, error) {
+	return p.ask("PIN:")
 }
 
-func makeBoolPointer(v bool) *bool {
-	return &v
+func (p execPrompter) GetPassphrase() (string, error) {
+	return p.ask("Passphrase:")
 }
 
-func trezorCall(
-	ctx context.Context,
-	api *trezorapi.API,
-	pbMessage proto.Message,
-	session string,
-	debugLink bool,
-) (proto.Message, error) {
-	res, err := trezorpbcall.Call(ctx, api, pbMessage, session, debugLink)
-
-	switch data := res.(type) {
-	case *trezorpb.ButtonRequest:
-		return trezorCall(ctx, api, &trezorpb.ButtonAck{}, session, debugLink)
-
-	case *trezorpb.PinMatrixRequest:
-		cmd := exec.Command("trezor-askpass", "PIN:")
-		cmd.Stdin = os.Stdin
-		cmd.Stderr = os.Stderr
-
-		out, err := cmd.Output()
-
-		checkError(err)
-
-		pin := string(out)
-
-		return trezorCall(ctx, api, &trezorpb.PinMatrixAck{Pin: &pin}, session, debugLink)
-
-	case *trezorpb.PassphraseRequest:
-		if data.OnDevice != nil && *data.OnDevice {
-			fmt.Fprintln(os.Stderr, "Passphrase requested on device")
-			return trezorCall(ctx, api, &trezorpb.PassphraseAck{Passphrase: nil}, session, debugLink)
-		}
-
-		cmd := exec.Command("trezor-askpass", "Passphrase:")
-		cmd.Stdin = os.Stdin
-		cmd.Stderr = os.Stderr
-
-		out, err := cmd.Output()
-
-		checkError(err)
-
-		pass := string(out)
+func (execPrompter) Confirm(prompt string) error {
+	fmt.Fprintln(os.Stderr, prompt)
+	return nil
+}
 
-		return trezorCall(ctx, api, &trezorpb.PassphraseAck{Passphrase: &pass}, session, debugLink)
+func usage() int {
+	flag.Usage()
+	return int(syscall.EINVAL)
+}
 
-	case *trezorpb.PassphraseStateRequest:
-		return trezorCall(ctx, api, &trezorpb.PassphraseStateAck{}, session, debugLink)
+func checkError(err error) {
+	if err == nil {
+		return
 	}
 
-	return res, err
+	fmt.Fprintln(os.Stderr, "Got error:", err)
+	os.Exit(255)
 }
 
 var (
-	hexInParam   = flag.Bool("Hi", false, "HEX encoded input")
-	hexOutParam  = flag.Bool("Ho", false, "HEX encoded output")
-	encryptParam = flag.Bool("e", false, "Encrypt value (default decrypt)")
+	encryptParam = flag.Bool("e", false, "Encrypt stdin (default decrypt)")
 	helpParam    = flag.Bool("h", false, "Show help message")
-	keyParam     = flag.String("k", "default key", "Sets TREZOR encryption/decryption key")
-	valueParam   = flag.String("v", "", "Value to encrypt (default TREZOR_CIPHER_VALUE variable)")
+	nameParam    = flag.String("name", "default key", "Sets TREZOR encryption/decryption key name")
+	pathParam    = flag.String("path", "m/10019'/0'", "SLIP-0011 derivation path for the encryption key")
+	nopadParam   = flag.Bool("nopad", false, "Don't PKCS#7-pad the final chunk; caller must align input to 16 bytes")
+	initParam    = flag.Bool("init", false, "Encrypt stdin to stdout as a TREZOR-wrapped file (see -unlock)")
+	unlockParam  = flag.Bool("unlock", false, "Decrypt stdin (produced by -init) to stdout")
+	listParam    = flag.Bool("list", false, "List connected TREZOR devices and exit")
+	deviceParam  = flag.String("device", "", "Pick a device by path, label or device ID (default first device found)")
 )
 
 func main() {
@@ -125,125 +90,48 @@ func main() {
 		os.Exit(0)
 	}
 
-	trezorAPI, err := trezorapi.New()
-	checkError(err)
-
-	// enumerating
-	ds, err := trezorAPI.Enumerate()
-	checkError(err)
-
-	if len(ds) < 1 {
-		fmt.Fprintln(os.Stderr, "No TREZOR device(s) found")
-		os.Exit(1)
-	}
-
-	d := ds[0]
+	ctx := context.Background()
 
-	// acquiring
-	debugLink := false
-	session, err := trezorAPI.Acquire(d.Path, d.Session, debugLink)
-	checkError(err)
-
-	// calling, automatically marshaling/demarshaling PB messages
-	res, err := trezorCall(
-		context.Background(),
-		trezorAPI,
-		&trezorpb.Initialize{},
-		session,
-		debugLink,
-	)
-	checkError(err)
-
-	switch typed := res.(type) {
-	case *trezorpb.Features:
-		if typed.BootloaderMode != nil && *typed.BootloaderMode {
-			fmt.Fprintf(os.Stderr, "Device is in bootloader mode\n")
+	if *listParam {
+		devices, err := trezorencrypt.Enumerate(ctx)
+		checkError(err)
 
-			// releasing
-			err = trezorAPI.Release(session, debugLink)
-			checkError(err)
+		for _, d := range devices {
+			fmt.Printf("%s\t%s\t%s\t%s\n", d.Path, d.Label, d.DeviceID, d.Version)
 		}
 
-		fmt.Fprintf(os.Stderr, "Device ID: %s (%s)\n", *typed.DeviceId, *typed.Label)
-	default:
-		fmt.Fprintln(os.Stderr, "Unknown type.")
+		return
 	}
 
-	var value []byte
+	addressN, err := trezorencrypt.ParsePath(*pathParam)
+	checkError(err)
 
-	// Try get Value from environment
-	if len(*valueParam) == 0 {
-		value = []byte(os.Getenv("TREZOR_CIPHER_VALUE"))
-	} else {
-		value = []byte(*valueParam)
+	var selector trezorencrypt.DeviceSelector
+	if *deviceParam != "" {
+		selector = trezorencrypt.AnySelector(*deviceParam)
 	}
 
-	if len(value) == 0 {
-		fmt.Fprintln(os.Stderr, "No value specified! Use eighter environment TREZOR_CIPHER_VALUE or -v param")
-
-		// releasing
-		err = trezorAPI.Release(session, debugLink)
-		if err != nil {
-			panic(err)
-		}
+	client, err := trezorencrypt.OpenWithPrompter(ctx, execPrompter{}, trezorencrypt.Options{AddressN: addressN, Selector: selector})
+	checkError(err)
+	defer client.Close()
 
-		os.Exit(1)
-	}
+	fmt.Fprintf(os.Stderr, "Device ID: %s (%s)\n", *client.Features.DeviceId, *client.Features.Label)
 
-	if *hexInParam {
-		value, _ = hex.DecodeString(string(value))
+	if *initParam {
+		checkError(client.EncryptFile(ctx, *nameParam, os.Stdout, os.Stdin))
+		return
 	}
 
-	if !*encryptParam {
-		if *hexInParam {
-			if len(value)%2 != 0 {
-				panic("Value is not valid HEX data")
-			}
-
-			hex.Decode(value, value)
-		}
+	if *unlockParam {
+		checkError(client.DecryptFile(ctx, *nameParam, os.Stdout, os.Stdin))
+		return
 	}
 
-	valueByte := value
-
-	paddedValue := make([]byte, 16*int(math.Ceil(float64(len(valueByte))/16)))
-	copy(paddedValue, valueByte)
-
-	res, err = trezorCall(
-		context.Background(),
-		trezorAPI,
-		&trezorpb.CipherKeyValue{
-			Key:          keyParam,
-			Value:        paddedValue,
-			Encrypt:      encryptParam,
-			AskOnDecrypt: makeBoolPointer(true),
-			AskOnEncrypt: makeBoolPointer(true),
-		},
-		session,
-		debugLink,
-	)
-	checkError(err)
-
-	switch data := res.(type) {
-	case *trezorpb.CipheredKeyValue:
-		if *hexOutParam {
-			data.Value = []byte(hex.EncodeToString(data.Value))
-		}
+	pad := !*nopadParam
 
-		fmt.Print(string(data.Value))
-	case *trezorpb.Failure:
-		fmt.Fprintf(os.Stderr, "Failure: %s\n", *data.Message)
-		err = trezorAPI.Release(session, debugLink)
-		checkError(err)
-		os.Exit(2)
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown type.")
-		err = trezorAPI.Release(session, debugLink)
-		checkError(err)
-		os.Exit(254)
+	if *encryptParam {
+		checkError(client.EncryptStream(ctx, *nameParam, os.Stdout, os.Stdin, pad))
+	} else {
+		checkError(client.DecryptStream(ctx, *nameParam, os.Stdout, os.Stdin, pad))
 	}
-
-	// releasing
-	err = trezorAPI.Release(session, debugLink)
-	checkError(err)
 }
@@ -0,0 +1,336 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package trezorencrypt wraps the TREZOR CipherKeyValue call behind a small
+// Client type so it can be embedded in tools other than the trezorencrypt
+// CLI (a FUSE filesystem, a password manager, ...). PIN and passphrase
+// gates surface as typed errors rather than blocking on a prompt, so
+// headless callers (daemons, RPC bridges) can resolve them out of band
+// and retry; interactive callers drive the same errors with a Prompter
+// and a small loop, same as the CLI does.
+package trezorencrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/trezor/trezord-go/trezorapi"
+	"github.com/trezor/trezord-go/trezorapi/trezorpb"
+	"github.com/trezor/trezord-go/trezorapi/trezorpb/trezorpbcall"
+)
+
+// pendingKind records which request is blocking the in-flight call,
+// i.e. which Provide*/ConfirmButton method is valid to call next.
+type pendingKind int
+
+const (
+	pendingNone pendingKind = iota
+	pendingButton
+	pendingPIN
+	pendingPassphrase
+)
+
+// Client talks to a single acquired TREZOR session.
+type Client struct {
+	api       *trezorapi.API
+	session   string
+	debugLink bool
+	addressN  []uint32
+	pending   pendingKind
+
+	Features *trezorpb.Features
+}
+
+// Options configures Open.
+type Options struct {
+	// AddressN namespaces the derived encryption key per SLIP-0011, e.g.
+	// the result of ParsePath("m/10019'/0'"). Nil uses the device's
+	// default, unnamespaced key.
+	AddressN []uint32
+
+	// Selector picks which connected device to acquire when more than
+	// one is plugged in. Nil falls back to the first device Enumerate
+	// returns, same as before device selection existed.
+	Selector DeviceSelector
+}
+
+func makeStringPointer(v string) *string {
+	return &v
+}
+
+func makeBoolPointer(v bool) *bool {
+	return &v
+}
+
+// Open enumerates connected devices, acquires one (the first found, or
+// the one opts.Selector picks) and initializes it. If the device needs a
+// PIN or passphrase, Open returns the acquired Client together with
+// ErrPINNeeded/ErrPassphraseNeeded: resolve it with ProvidePIN/
+// ProvidePassphrase and call Init again.
+func Open(ctx context.Context, opts Options) (*Client, error) {
+	api, err := trezorapi.New()
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := api.Enumerate()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ds) < 1 {
+		return nil, fmt.Errorf("no TREZOR device(s) found")
+	}
+
+	d := ds[0]
+
+	if opts.Selector != nil {
+		d, err = selectDevice(ctx, api, ds, opts.Selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return open(ctx, api, d.Path, d.Session, opts)
+}
+
+// OpenWithPrompter is a convenience over Open for interactive callers (a
+// FUSE filesystem, a password manager, ...) that would rather hand PIN/
+// passphrase/button requests to a Prompter than drive ErrPINNeeded/
+// ErrPassphraseNeeded/ErrButtonNeeded themselves: it retries Open's
+// Initialize handshake, resolving each request through prompter, until the
+// device is ready or prompter itself fails (e.g. a headless Prompter that
+// always errors).
+func OpenWithPrompter(ctx context.Context, prompter Prompter, opts Options) (*Client, error) {
+	client, err := Open(ctx, opts)
+
+	for {
+		switch {
+		case err == nil:
+			return client, nil
+
+		case errors.Is(err, ErrPINNeeded):
+			pin, askErr := prompter.GetPIN()
+			if askErr != nil {
+				return client, askErr
+			}
+
+			if _, err = client.ProvidePIN(ctx, pin); err == nil {
+				err = client.Init(ctx)
+			}
+
+		case errors.Is(err, ErrPassphraseNeeded):
+			pass, askErr := prompter.GetPassphrase()
+			if askErr != nil {
+				return client, askErr
+			}
+
+			if _, err = client.ProvidePassphrase(ctx, pass); err == nil {
+				err = client.Init(ctx)
+			}
+
+		case errors.Is(err, ErrButtonNeeded):
+			if askErr := prompter.Confirm("Confirm the action on the TREZOR"); askErr != nil {
+				return client, askErr
+			}
+
+			if _, err = client.ConfirmButton(ctx); err == nil {
+				err = client.Init(ctx)
+			}
+
+		default:
+			return client, err
+		}
+	}
+}
+
+func open(ctx context.Context, api *trezorapi.API, path, prevSession string, opts Options) (*Client, error) {
+	session, err := api.Acquire(path, prevSession, false)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		api:      api,
+		session:  session,
+		addressN: opts.AddressN,
+	}
+
+	if err := c.Init(ctx); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// Init performs the Initialize handshake and stores the resulting
+// Features. It is called automatically by Open, and is the "original
+// call" a headless caller retries after resolving ErrPINNeeded or
+// ErrPassphraseNeeded via ProvidePIN/ProvidePassphrase.
+func (c *Client) Init(ctx context.Context) error {
+	res, err := c.call(ctx, &trezorpb.Initialize{})
+	if err != nil {
+		return err
+	}
+
+	features, ok := res.(*trezorpb.Features)
+	if !ok {
+		return fmt.Errorf("unexpected response to Initialize: %T", res)
+	}
+
+	if features.BootloaderMode != nil && *features.BootloaderMode {
+		return fmt.Errorf("device is in bootloader mode")
+	}
+
+	c.Features = features
+
+	return nil
+}
+
+// Close releases the acquired session.
+func (c *Client) Close() error {
+	return c.api.Release(c.session, c.debugLink)
+}
+
+// call performs a single TREZOR protocol call. PinMatrixRequest and
+// PassphraseRequest (off device) leave the call pending and surface as
+// ErrPINNeeded/ErrPassphraseNeeded; ButtonRequest surfaces as
+// ErrButtonNeeded. A Failure response becomes a *FailureError. Callers
+// resolve a pending request with ProvidePIN, ProvidePassphrase or
+// ConfirmButton and retry.
+func (c *Client) call(ctx context.Context, pbMessage proto.Message) (proto.Message, error) {
+	res, err := trezorpbcall.Call(ctx, c.api, pbMessage, c.session, c.debugLink)
+	if err != nil {
+		return res, err
+	}
+
+	switch data := res.(type) {
+	case *trezorpb.ButtonRequest:
+		c.pending = pendingButton
+		return nil, ErrButtonNeeded
+
+	case *trezorpb.PinMatrixRequest:
+		c.pending = pendingPIN
+		return nil, ErrPINNeeded
+
+	case *trezorpb.PassphraseRequest:
+		if data.OnDevice != nil && *data.OnDevice {
+			return c.call(ctx, &trezorpb.PassphraseAck{Passphrase: nil})
+		}
+
+		c.pending = pendingPassphrase
+		return nil, ErrPassphraseNeeded
+
+	case *trezorpb.PassphraseStateRequest:
+		return c.call(ctx, &trezorpb.PassphraseStateAck{})
+
+	case *trezorpb.Failure:
+		return nil, &FailureError{Code: data.Code, Message: data.GetMessage()}
+	}
+
+	return res, nil
+}
+
+// ProvidePIN answers a pending PinMatrixRequest and resumes the call that
+// raised ErrPINNeeded.
+func (c *Client) ProvidePIN(ctx context.Context, pin string) (proto.Message, error) {
+	if c.pending != pendingPIN {
+		return nil, fmt.Errorf("trezorencrypt: no PIN request pending")
+	}
+
+	c.pending = pendingNone
+
+	return c.call(ctx, &trezorpb.PinMatrixAck{Pin: &pin})
+}
+
+// ProvidePassphrase answers a pending PassphraseRequest and resumes the
+// call that raised ErrPassphraseNeeded.
+func (c *Client) ProvidePassphrase(ctx context.Context, passphrase string) (proto.Message, error) {
+	if c.pending != pendingPassphrase {
+		return nil, fmt.Errorf("trezorencrypt: no passphrase request pending")
+	}
+
+	c.pending = pendingNone
+
+	return c.call(ctx, &trezorpb.PassphraseAck{Passphrase: &passphrase})
+}
+
+// ConfirmButton answers a pending ButtonRequest and resumes the call that
+// raised ErrButtonNeeded. Unlike PIN/passphrase, no secret is required;
+// the device itself blocks until its button is physically pressed.
+func (c *Client) ConfirmButton(ctx context.Context) (proto.Message, error) {
+	if c.pending != pendingButton {
+		return nil, fmt.Errorf("trezorencrypt: no button confirmation pending")
+	}
+
+	c.pending = pendingNone
+
+	return c.call(ctx, &trezorpb.ButtonAck{})
+}
+
+// cipherKeyValue runs value through the device's CipherKeyValue call under
+// key. CipherKeyValue requires value to already be a multiple of 16 bytes;
+// callers that need to cipher arbitrary-length data must pad it themselves
+// (and strip the padding back out on decrypt) rather than rely on
+// cipherKeyValue to do it silently, since a silent pad can't be
+// distinguished from real trailing data on the way back out.
+// AskOnEncrypt/AskOnDecrypt means every call raises a ButtonRequest; since
+// confirming it needs no caller-supplied data, it is resolved here rather
+// than bubbled up as ErrButtonNeeded.
+func (c *Client) cipherKeyValue(ctx context.Context, key string, value []byte, encrypt bool) ([]byte, error) {
+	if len(value)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("trezorencrypt: value is %d bytes, not a multiple of %d", len(value), aes.BlockSize)
+	}
+
+	res, err := c.call(ctx, &trezorpb.CipherKeyValue{
+		Key:          makeStringPointer(key),
+		Value:        value,
+		Encrypt:      makeBoolPointer(encrypt),
+		AskOnDecrypt: makeBoolPointer(true),
+		AskOnEncrypt: makeBoolPointer(true),
+		AddressN:     c.addressN,
+	})
+
+	for errors.Is(err, ErrButtonNeeded) {
+		res, err = c.ConfirmButton(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch data := res.(type) {
+	case *trezorpb.CipheredKeyValue:
+		return data.Value, nil
+	default:
+		return nil, fmt.Errorf("unexpected response to CipherKeyValue: %T", res)
+	}
+}
+
+// Encrypt ciphers value under key using the device's CipherKeyValue call.
+// value must already be a multiple of 16 bytes; pad it yourself (e.g. with
+// PKCS#7, and strip the padding back out after Decrypt) if it might not be.
+func (c *Client) Encrypt(ctx context.Context, key string, value []byte) ([]byte, error) {
+	return c.cipherKeyValue(ctx, key, value, true)
+}
+
+// Decrypt deciphers value under key using the device's CipherKeyValue call.
+// value must already be a multiple of 16 bytes, same as Encrypt.
+func (c *Client) Decrypt(ctx context.Context, key string, value []byte) ([]byte, error) {
+	return c.cipherKeyValue(ctx, key, value, false)
+}
@@ -0,0 +1,151 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trezor/trezord-go/trezorapi"
+	"github.com/trezor/trezord-go/trezorapi/trezorpb"
+)
+
+// DeviceInfo describes a connected TREZOR, as reported by its Features
+// after initializing it.
+type DeviceInfo struct {
+	Path     string
+	Session  string
+	Label    string
+	DeviceID string
+	Version  string
+}
+
+// DeviceSelector reports whether a device matches some caller-defined
+// criteria, so programmatic users aren't limited to the CLI's
+// path/label/ID shorthand.
+type DeviceSelector func(DeviceInfo) bool
+
+// ByPath selects a device by its USB path.
+func ByPath(path string) DeviceSelector {
+	return func(d DeviceInfo) bool { return d.Path == path }
+}
+
+// ByLabel selects a device by its Label.
+func ByLabel(label string) DeviceSelector {
+	return func(d DeviceInfo) bool { return d.Label == label }
+}
+
+// ByDeviceID selects a device by its DeviceID.
+func ByDeviceID(id string) DeviceSelector {
+	return func(d DeviceInfo) bool { return d.DeviceID == id }
+}
+
+// AnySelector matches a device whose path, label or device ID equals s.
+func AnySelector(s string) DeviceSelector {
+	return func(d DeviceInfo) bool {
+		return d.Path == s || d.Label == s || d.DeviceID == s
+	}
+}
+
+// Enumerate lists every connected device along with the Features reported
+// by briefly acquiring and initializing it. A device that can't be
+// described this way (e.g. it's in bootloader mode, or needs a PIN or
+// passphrase to initialize) is skipped rather than aborting the whole
+// listing.
+func Enumerate(ctx context.Context) ([]DeviceInfo, error) {
+	api, err := trezorapi.New()
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := api.Enumerate()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]DeviceInfo, 0, len(ds))
+
+	for _, d := range ds {
+		if info, ok := describe(ctx, api, d); ok {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos, nil
+}
+
+// describe acquires d just long enough to read its Features, releasing the
+// session again before it returns. ok is false when the device was
+// reachable but couldn't be initialized (bootloader mode, needs a PIN/
+// passphrase, ...); such a device is reported as unusable rather than
+// failing the caller's whole enumeration.
+func describe(ctx context.Context, api *trezorapi.API, d trezorapi.Device) (info DeviceInfo, ok bool) {
+	c, err := open(ctx, api, d.Path, d.Session, Options{})
+	if c != nil {
+		defer c.Close()
+	}
+
+	if err != nil {
+		return DeviceInfo{}, false
+	}
+
+	return DeviceInfo{
+		Path:     d.Path,
+		Session:  d.Session,
+		Label:    strValue(c.Features.Label),
+		DeviceID: strValue(c.Features.DeviceId),
+		Version:  firmwareVersion(c.Features),
+	}, true
+}
+
+func selectDevice(ctx context.Context, api *trezorapi.API, ds []trezorapi.Device, selector DeviceSelector) (trezorapi.Device, error) {
+	var matched []trezorapi.Device
+
+	for _, d := range ds {
+		if info, ok := describe(ctx, api, d); ok && selector(info) {
+			matched = append(matched, d)
+		}
+	}
+
+	switch len(matched) {
+	case 0:
+		return trezorapi.Device{}, fmt.Errorf("no TREZOR device matches the given selector")
+	case 1:
+		return matched[0], nil
+	default:
+		return trezorapi.Device{}, fmt.Errorf("%d TREZOR devices match the given selector, need exactly one", len(matched))
+	}
+}
+
+func strValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func u32Value(v *uint32) uint32 {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
+
+func firmwareVersion(f *trezorpb.Features) string {
+	return fmt.Sprintf("%d.%d.%d", u32Value(f.MajorVersion), u32Value(f.MinorVersion), u32Value(f.PatchVersion))
+}
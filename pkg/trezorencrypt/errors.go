@@ -0,0 +1,54 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/trezor/trezord-go/trezorapi/trezorpb"
+)
+
+var (
+	// ErrPINNeeded is returned when a call is blocked on a PinMatrixRequest.
+	// Resolve it with Client.ProvidePIN, then retry the call.
+	ErrPINNeeded = errors.New("trezorencrypt: TREZOR requires a PIN")
+
+	// ErrPassphraseNeeded is returned when a call is blocked on a
+	// PassphraseRequest. Resolve it with Client.ProvidePassphrase, then
+	// retry the call.
+	ErrPassphraseNeeded = errors.New("trezorencrypt: TREZOR requires a passphrase")
+
+	// ErrButtonNeeded is returned when a call is blocked on a
+	// ButtonRequest. Resolve it with Client.ConfirmButton.
+	ErrButtonNeeded = errors.New("trezorencrypt: TREZOR requires confirmation on the device")
+)
+
+// FailureError wraps a Failure message reported by the device, following
+// the pattern go-ethereum's usbwallet TREZOR driver uses: surface the raw
+// code and message instead of flattening them into a generic error string.
+type FailureError struct {
+	Code    *trezorpb.Failure_FailureType
+	Message string
+}
+
+func (e *FailureError) Error() string {
+	if e.Code == nil {
+		return fmt.Sprintf("trezor: %s", e.Message)
+	}
+
+	return fmt.Sprintf("trezor: %s (%v)", e.Message, *e.Code)
+}
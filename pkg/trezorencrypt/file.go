@@ -0,0 +1,304 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// File encryption treats the TREZOR as a key-wrapping oracle rather than the
+// bulk cipher: a random Payload is sent through CipherKeyValue to derive a
+// per-file wrapping key, which in turn AES-GCM-wraps a random file encryption
+// key (FEK). The FEK then protects the actual contents, chunked into frames
+// so files of any size can stream through without buffering in RAM. The
+// header stores the Payload (never the derived wrapping key), and each file
+// gets a fresh random Payload, so the same TREZOR secret never wraps two
+// FEKs identically.
+const (
+	fileMagic     = "TZEF"
+	fileVersion   = 1
+	payloadSize   = 32
+	fekSize       = 32
+	gcmNonceSize  = 12
+	fileChunkSize = 64 * 1024
+)
+
+var (
+	// ErrBadMagic is returned when the input does not start with the
+	// trezorencrypt file magic.
+	ErrBadMagic = errors.New("trezorencrypt: not a trezorencrypt file")
+
+	// ErrUnsupportedVersion is returned for a header whose version this
+	// build doesn't know how to read.
+	ErrUnsupportedVersion = errors.New("trezorencrypt: unsupported file version")
+
+	// ErrTruncatedFrame is returned when a content frame is shorter than
+	// the nonce it must carry.
+	ErrTruncatedFrame = errors.New("trezorencrypt: truncated frame")
+)
+
+type fileHeader struct {
+	Payload    [payloadSize]byte
+	WrapNonce  [gcmNonceSize]byte
+	WrappedFEK []byte
+}
+
+func writeHeader(w io.Writer, h *fileHeader) error {
+	if _, err := io.WriteString(w, fileMagic); err != nil {
+		return err
+	}
+
+	if _, err := w.Write([]byte{fileVersion}); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(h.Payload[:]); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(h.WrapNonce[:]); err != nil {
+		return err
+	}
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(h.WrappedFEK)))
+
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(h.WrappedFEK)
+	return err
+}
+
+func readHeader(r io.Reader) (*fileHeader, error) {
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+
+	if string(magic) != fileMagic {
+		return nil, ErrBadMagic
+	}
+
+	var version [1]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return nil, err
+	}
+
+	if version[0] != fileVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	h := &fileHeader{}
+
+	if _, err := io.ReadFull(r, h.Payload[:]); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r, h.WrapNonce[:]); err != nil {
+		return nil, err
+	}
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	h.WrappedFEK = make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(r, h.WrappedFEK); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// EncryptFile encrypts r into w, deriving the wrapping key for a fresh file
+// encryption key from a random Payload run through the device's
+// CipherKeyValue under key.
+func (c *Client) EncryptFile(ctx context.Context, key string, w io.Writer, r io.Reader) error {
+	payload := make([]byte, payloadSize)
+	if _, err := rand.Read(payload); err != nil {
+		return err
+	}
+
+	wrapKey, err := c.Encrypt(ctx, key, payload)
+	if err != nil {
+		return err
+	}
+
+	return encryptFile(wrapKey, payload, w, r)
+}
+
+// DecryptFile decrypts r (as produced by EncryptFile) into w, re-deriving
+// the wrapping key from the Payload stored in the file's header.
+func (c *Client) DecryptFile(ctx context.Context, key string, w io.Writer, r io.Reader) error {
+	hdr, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	wrapKey, err := c.Encrypt(ctx, key, hdr.Payload[:])
+	if err != nil {
+		return err
+	}
+
+	return decryptFile(wrapKey, hdr, w, r)
+}
+
+// encryptFile does the actual AES-GCM wrapping/chunking given an
+// already-derived wrapKey, independent of how that key was obtained.
+func encryptFile(wrapKey, payload []byte, w io.Writer, r io.Reader) error {
+	fek := make([]byte, fekSize)
+	if _, err := rand.Read(fek); err != nil {
+		return err
+	}
+
+	wrapGCM, err := newGCM(wrapKey)
+	if err != nil {
+		return err
+	}
+
+	hdr := &fileHeader{}
+	copy(hdr.Payload[:], payload)
+
+	if _, err := rand.Read(hdr.WrapNonce[:]); err != nil {
+		return err
+	}
+
+	hdr.WrappedFEK = wrapGCM.Seal(nil, hdr.WrapNonce[:], fek, nil)
+
+	if err := writeHeader(w, hdr); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(fek)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, fileChunkSize)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := writeFrame(w, gcm, buf[:n]); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decryptFile unwraps the FEK from hdr using wrapKey and decrypts the
+// frames remaining in r into w.
+func decryptFile(wrapKey []byte, hdr *fileHeader, w io.Writer, r io.Reader) error {
+	wrapGCM, err := newGCM(wrapKey)
+	if err != nil {
+		return err
+	}
+
+	fek, err := wrapGCM.Open(nil, hdr.WrapNonce[:], hdr.WrappedFEK, nil)
+	if err != nil {
+		return fmt.Errorf("trezorencrypt: unwrap file key: %w", err)
+	}
+
+	gcm, err := newGCM(fek)
+	if err != nil {
+		return err
+	}
+
+	for {
+		plaintext, err := readFrame(r, gcm)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeFrame seals plaintext and writes it as a length-prefixed
+// nonce||ciphertext||tag frame.
+func writeFrame(w io.Writer, gcm cipher.AEAD, plaintext []byte) error {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	frame := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(frame)))
+
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads and opens the next length-prefixed frame, returning
+// io.EOF once the stream is exhausted on a frame boundary.
+func readFrame(r io.Reader, gcm cipher.AEAD) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(frame) < nonceSize {
+		return nil, ErrTruncatedFrame
+	}
+
+	nonce, ciphertext := frame[:nonceSize], frame[nonceSize:]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
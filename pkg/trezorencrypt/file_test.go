@@ -0,0 +1,140 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testWrapKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestFileRoundTrip(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog"), 5000)
+	payload := bytes.Repeat([]byte{0x01}, payloadSize)
+
+	var encrypted bytes.Buffer
+	if err := encryptFile(testWrapKey, payload, &encrypted, bytes.NewReader(plaintext)); err != nil {
+		t.Fatalf("encryptFile: %v", err)
+	}
+
+	hdr, err := readHeader(&encrypted)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := decryptFile(testWrapKey, hdr, &decrypted, &encrypted); err != nil {
+		t.Fatalf("decryptFile: %v", err)
+	}
+
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Fatalf("round-tripped content does not match: got %d bytes, want %d", decrypted.Len(), len(plaintext))
+	}
+}
+
+func TestFileRoundTripEmpty(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x02}, payloadSize)
+
+	var encrypted bytes.Buffer
+	if err := encryptFile(testWrapKey, payload, &encrypted, bytes.NewReader(nil)); err != nil {
+		t.Fatalf("encryptFile: %v", err)
+	}
+
+	hdr, err := readHeader(&encrypted)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+
+	var decrypted bytes.Buffer
+	if err := decryptFile(testWrapKey, hdr, &decrypted, &encrypted); err != nil {
+		t.Fatalf("decryptFile: %v", err)
+	}
+
+	if decrypted.Len() != 0 {
+		t.Fatalf("expected no content, got %d bytes", decrypted.Len())
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	hdr := &fileHeader{WrappedFEK: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	copy(hdr.Payload[:], bytes.Repeat([]byte{0xAB}, payloadSize))
+	copy(hdr.WrapNonce[:], bytes.Repeat([]byte{0xCD}, gcmNonceSize))
+
+	var buf bytes.Buffer
+	if err := writeHeader(&buf, hdr); err != nil {
+		t.Fatalf("writeHeader: %v", err)
+	}
+
+	got, err := readHeader(&buf)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+
+	if got.Payload != hdr.Payload || got.WrapNonce != hdr.WrapNonce || !bytes.Equal(got.WrappedFEK, hdr.WrappedFEK) {
+		t.Fatalf("header did not round-trip: got %+v, want %+v", got, hdr)
+	}
+}
+
+func TestHeaderBadMagic(t *testing.T) {
+	buf := bytes.NewBufferString("NOPE")
+	if _, err := readHeader(buf); err != ErrBadMagic {
+		t.Fatalf("expected ErrBadMagic, got %v", err)
+	}
+}
+
+func TestFileTamperedWrappedFEK(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x03}, payloadSize)
+
+	var encrypted bytes.Buffer
+	if err := encryptFile(testWrapKey, payload, &encrypted, bytes.NewReader([]byte("secret"))); err != nil {
+		t.Fatalf("encryptFile: %v", err)
+	}
+
+	hdr, err := readHeader(&encrypted)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+
+	hdr.WrappedFEK[0] ^= 0xFF
+
+	if err := decryptFile(testWrapKey, hdr, &bytes.Buffer{}, &encrypted); err == nil {
+		t.Fatal("expected tamper detection error, got nil")
+	}
+}
+
+func TestFileTamperedFrame(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x04}, payloadSize)
+
+	var encrypted bytes.Buffer
+	if err := encryptFile(testWrapKey, payload, &encrypted, bytes.NewReader([]byte("secret data"))); err != nil {
+		t.Fatalf("encryptFile: %v", err)
+	}
+
+	raw := encrypted.Bytes()
+	raw[len(raw)-1] ^= 0xFF
+
+	buf := bytes.NewReader(raw)
+
+	hdr, err := readHeader(buf)
+	if err != nil {
+		t.Fatalf("readHeader: %v", err)
+	}
+
+	if err := decryptFile(testWrapKey, hdr, &bytes.Buffer{}, buf); err == nil {
+		t.Fatal("expected tamper detection error, got nil")
+	}
+}
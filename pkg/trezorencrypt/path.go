@@ -0,0 +1,64 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hardenedBit is ORed into a path component's index when it is suffixed
+// with ' or h, per BIP32.
+const hardenedBit = 0x80000000
+
+// ParsePath parses a BIP32-style derivation path such as "m/10019'/0'"
+// into the AddressN TREZOR's CipherKeyValue expects. This namespaces the
+// derived encryption key per SLIP-0011, so callers can keep several
+// independent encryption contexts on one seed.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("trezorencrypt: path %q must start with \"m\"", path)
+	}
+
+	addressN := make([]uint32, 0, len(parts)-1)
+
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("trezorencrypt: invalid path component %q: %w", part, err)
+		}
+
+		if n >= hardenedBit {
+			return nil, fmt.Errorf("trezorencrypt: path component %q out of range", part)
+		}
+
+		if hardened {
+			n |= hardenedBit
+		}
+
+		addressN = append(addressN, uint32(n))
+	}
+
+	return addressN, nil
+}
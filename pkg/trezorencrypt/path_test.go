@@ -0,0 +1,59 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		path string
+		want []uint32
+	}{
+		{"m/10019'/0'", []uint32{10019 | hardenedBit, 0 | hardenedBit}},
+		{"m/10019h/0h", []uint32{10019 | hardenedBit, 0 | hardenedBit}},
+		{"m/44'/0'/0'/0/0", []uint32{44 | hardenedBit, 0 | hardenedBit, 0 | hardenedBit, 0, 0}},
+		{"m", []uint32{}},
+	}
+
+	for _, c := range cases {
+		got, err := ParsePath(c.path)
+		if err != nil {
+			t.Fatalf("ParsePath(%q): %v", c.path, err)
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParsePath(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestParsePathInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"10019'/0'",
+		"m/abc",
+		"m/4294967296",
+	}
+
+	for _, path := range cases {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%q): expected error, got nil", path)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+// Prompter supplies the human interaction a TREZOR call may need while it is
+// in flight. Implementations back it with whatever UI makes sense for the
+// caller: a terminal prompt, a GUI dialog, a FUSE-mounted passphrase file, or
+// a headless stub that always errors.
+type Prompter interface {
+	// GetPIN returns the PIN matrix response for a PinMatrixRequest.
+	GetPIN() (string, error)
+
+	// GetPassphrase returns the passphrase for a PassphraseRequest.
+	GetPassphrase() (string, error)
+
+	// Confirm tells the user a button press is required on the device and
+	// gives the implementation a chance to fail the call instead (e.g. a
+	// headless caller that has no one to show the prompt to).
+	Confirm(prompt string) error
+}
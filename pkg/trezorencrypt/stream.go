@@ -0,0 +1,232 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// streamChunkSize is the unit EncryptStream/DecryptStream read and call
+// CipherKeyValue with; it is a multiple of aes.BlockSize so only the final,
+// possibly-short chunk needs padding.
+const streamChunkSize = 64 * 1024
+
+// EncryptStream reads plaintext from r in fixed-size chunks and writes the
+// ciphertext for each to w, so input of any size can be piped through
+// without ever buffering the whole thing in memory. CipherKeyValue only
+// accepts values that are a multiple of 16 bytes, so unless pad is false
+// the final chunk is PKCS#7-padded; with pad false the caller is
+// responsible for aligning its input (e.g. already-framed data). Padding
+// a block-aligned final chunk appends a whole extra block, so each
+// ciphertext segment is written with a 4-byte length prefix (same framing
+// as file.go's frames) letting DecryptStream read back exactly the bytes
+// each CipherKeyValue call produced instead of guessing at streamChunkSize
+// boundaries.
+func (c *Client) EncryptStream(ctx context.Context, key string, w io.Writer, r io.Reader, pad bool) error {
+	return streamEncrypt(w, r, pad, func(chunk []byte) ([]byte, error) {
+		return c.Encrypt(ctx, key, chunk)
+	})
+}
+
+// DecryptStream is the inverse of EncryptStream.
+func (c *Client) DecryptStream(ctx context.Context, key string, w io.Writer, r io.Reader, pad bool) error {
+	return streamDecrypt(w, r, pad, func(frame []byte) ([]byte, error) {
+		return c.Decrypt(ctx, key, frame)
+	})
+}
+
+// streamEncrypt drives EncryptStream's chunking/padding/framing against an
+// injected cipher func, so it can be exercised in tests without a device.
+func streamEncrypt(w io.Writer, r io.Reader, pad bool, cipher func(chunk []byte) ([]byte, error)) error {
+	return streamChunks(r, streamChunkSize, func(chunk []byte, last bool) error {
+		if last && pad {
+			chunk = pkcs7Pad(chunk, aes.BlockSize)
+		}
+
+		if len(chunk)%aes.BlockSize != 0 {
+			return fmt.Errorf("trezorencrypt: chunk is %d bytes, not a multiple of 16; use padding or align input", len(chunk))
+		}
+
+		ciphertext, err := cipher(chunk)
+		if err != nil {
+			return err
+		}
+
+		return writeStreamFrame(w, ciphertext)
+	})
+}
+
+// streamDecrypt is the inverse of streamEncrypt.
+func streamDecrypt(w io.Writer, r io.Reader, pad bool, cipher func(frame []byte) ([]byte, error)) error {
+	return streamFrames(r, func(frame []byte, last bool) error {
+		plaintext, err := cipher(frame)
+		if err != nil {
+			return err
+		}
+
+		if last && pad {
+			plaintext, err = pkcs7Unpad(plaintext, aes.BlockSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = w.Write(plaintext)
+		return err
+	})
+}
+
+// writeStreamFrame writes data as a 4-byte big-endian length prefix
+// followed by data itself.
+func writeStreamFrame(w io.Writer, data []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// readStreamFrame reads a single length-prefixed frame written by
+// writeStreamFrame.
+func readStreamFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// streamFrames reads r as a sequence of writeStreamFrame frames and calls
+// process for each, looking one frame ahead so the final frame can be
+// reported with last=true without buffering the rest of the stream. This
+// mirrors streamChunks, except frame boundaries come from the length
+// prefix instead of a fixed chunk size, since a padded final chunk can
+// make the last ciphertext segment larger than streamChunkSize.
+func streamFrames(r io.Reader, process func(frame []byte, last bool) error) error {
+	pending, err := readStreamFrame(r)
+	if err == io.EOF {
+		return process(nil, true)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		next, err := readStreamFrame(r)
+		if err == io.EOF {
+			return process(pending, true)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := process(pending, false); err != nil {
+			return err
+		}
+
+		pending = next
+	}
+}
+
+// streamChunks reads r in chunkSize pieces and calls process for each,
+// looking one chunk ahead so the final (possibly short) chunk can be
+// reported with last=true without buffering the rest of the stream.
+func streamChunks(r io.Reader, chunkSize int, process func(chunk []byte, last bool) error) error {
+	pending, err := readChunk(r, chunkSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	for {
+		next, err := readChunk(r, chunkSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if err == io.EOF && len(next) == 0 {
+			return process(pending, true)
+		}
+
+		if err := process(pending, false); err != nil {
+			return err
+		}
+
+		pending = next
+	}
+}
+
+func readChunk(r io.Reader, chunkSize int) ([]byte, error) {
+	buf := make([]byte, chunkSize)
+
+	n, err := io.ReadFull(r, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+
+	return buf[:n], err
+}
+
+// pkcs7Pad pads data to a multiple of blockSize per PKCS#7 (RFC 5652 6.3),
+// always appending at least one byte of padding so the scheme stays
+// unambiguous on an input that's already block-aligned.
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padLen := blockSize - len(data)%blockSize
+	padded := make([]byte, len(data)+padLen)
+	copy(padded, data)
+
+	for i := len(data); i < len(padded); i++ {
+		padded[i] = byte(padLen)
+	}
+
+	return padded
+}
+
+// pkcs7Unpad reverses pkcs7Pad, rejecting malformed padding rather than
+// silently truncating it.
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 || len(data)%blockSize != 0 {
+		return nil, fmt.Errorf("trezorencrypt: padded data length %d is not a multiple of %d", len(data), blockSize)
+	}
+
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > blockSize || padLen > len(data) {
+		return nil, fmt.Errorf("trezorencrypt: invalid PKCS#7 padding")
+	}
+
+	for _, b := range data[len(data)-padLen:] {
+		if int(b) != padLen {
+			return nil, fmt.Errorf("trezorencrypt: invalid PKCS#7 padding")
+		}
+	}
+
+	return data[:len(data)-padLen], nil
+}
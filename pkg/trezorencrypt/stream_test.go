@@ -0,0 +1,181 @@
+/*
+  This program is free software: you can redistribute it and/or modify
+  it under the terms of the GNU General Public License as published by
+  the Free Software Foundation, either version 3 of the License, or
+  (at your option) any later version.
+
+  This program is distributed in the hope that it will be useful,
+  but WITHOUT ANY WARRANTY; without even the implied warranty of
+  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+  GNU General Public License for more details.
+
+  You should have received a copy of the GNU General Public License
+  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package trezorencrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// cbcZeroIVCipher returns an encrypt/decrypt pair standing in for
+// CipherKeyValue in tests: like the real call, each invocation is
+// independent (a fresh zero IV every time, no chaining across calls) and
+// only accepts block-aligned input, but it needs no hardware.
+func cbcZeroIVCipher(t *testing.T, key []byte) (encrypt, decrypt func([]byte) ([]byte, error)) {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("aes.NewCipher: %v", err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+
+	encrypt = func(chunk []byte) ([]byte, error) {
+		out := make([]byte, len(chunk))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, chunk)
+		return out, nil
+	}
+
+	decrypt = func(chunk []byte) ([]byte, error) {
+		out := make([]byte, len(chunk))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, chunk)
+		return out, nil
+	}
+
+	return encrypt, decrypt
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x11}, 32)
+
+	for _, size := range []int{streamChunkSize, streamChunkSize * 2, streamChunkSize*2 + 16, 0, 1, 45} {
+		plaintext := bytes.Repeat([]byte{0x5}, size)
+		encrypt, decrypt := cbcZeroIVCipher(t, key)
+
+		var ciphertext bytes.Buffer
+		if err := streamEncrypt(&ciphertext, bytes.NewReader(plaintext), true, encrypt); err != nil {
+			t.Fatalf("size %d: streamEncrypt: %v", size, err)
+		}
+
+		var decrypted bytes.Buffer
+		if err := streamDecrypt(&decrypted, &ciphertext, true, decrypt); err != nil {
+			t.Fatalf("size %d: streamDecrypt: %v", size, err)
+		}
+
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Fatalf("size %d: round-tripped content does not match: got %d bytes, want %d", size, decrypted.Len(), len(plaintext))
+		}
+	}
+}
+
+func TestPKCS7RoundTrip(t *testing.T) {
+	cases := [][]byte{
+		nil,
+		[]byte("short"),
+		bytes.Repeat([]byte{0x7}, 16),
+		bytes.Repeat([]byte{0x7}, 33),
+	}
+
+	for _, data := range cases {
+		padded := pkcs7Pad(data, 16)
+
+		if len(padded)%16 != 0 {
+			t.Fatalf("pkcs7Pad(%d bytes): result length %d is not block-aligned", len(data), len(padded))
+		}
+
+		if len(padded) <= len(data) {
+			t.Fatalf("pkcs7Pad(%d bytes): expected at least one byte of padding, got %d", len(data), len(padded))
+		}
+
+		got, err := pkcs7Unpad(padded, 16)
+		if err != nil {
+			t.Fatalf("pkcs7Unpad: %v", err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Fatalf("pkcs7Unpad round-trip mismatch: got %v, want %v", got, data)
+		}
+	}
+}
+
+func TestPKCS7UnpadRejectsBadPadding(t *testing.T) {
+	cases := [][]byte{
+		{},
+		bytes.Repeat([]byte{0}, 15),
+		append(bytes.Repeat([]byte{0}, 15), 0x00),
+		append(bytes.Repeat([]byte{0}, 15), 0x11),
+	}
+
+	for _, data := range cases {
+		if _, err := pkcs7Unpad(data, 16); err == nil {
+			t.Errorf("pkcs7Unpad(%v): expected error, got nil", data)
+		}
+	}
+}
+
+func TestStreamChunks(t *testing.T) {
+	data := bytes.Repeat([]byte{0x9}, 45)
+
+	var chunks [][]byte
+	var lastFlags []bool
+
+	err := streamChunks(bytes.NewReader(data), 16, func(chunk []byte, last bool) error {
+		chunks = append(chunks, append([]byte(nil), chunk...))
+		lastFlags = append(lastFlags, last)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("streamChunks: %v", err)
+	}
+
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(chunks))
+	}
+
+	if len(chunks[0]) != 16 || len(chunks[1]) != 16 || len(chunks[2]) != 13 {
+		t.Fatalf("unexpected chunk lengths: %d, %d, %d", len(chunks[0]), len(chunks[1]), len(chunks[2]))
+	}
+
+	if lastFlags[0] || lastFlags[1] || !lastFlags[2] {
+		t.Fatalf("unexpected last flags: %v", lastFlags)
+	}
+
+	var reassembled []byte
+	for _, c := range chunks {
+		reassembled = append(reassembled, c...)
+	}
+
+	if !bytes.Equal(reassembled, data) {
+		t.Fatal("reassembled chunks do not match original data")
+	}
+}
+
+func TestStreamChunksEmpty(t *testing.T) {
+	var got []byte
+	var last bool
+	calls := 0
+
+	err := streamChunks(bytes.NewReader(nil), 16, func(chunk []byte, l bool) error {
+		calls++
+		got = chunk
+		last = l
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("streamChunks: %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("expected exactly 1 call for empty input, got %d", calls)
+	}
+
+	if len(got) != 0 || !last {
+		t.Fatalf("expected a single empty, final chunk, got %v last=%v", got, last)
+	}
+}